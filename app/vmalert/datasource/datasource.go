@@ -0,0 +1,109 @@
+package datasource
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Querier interface wraps Query and QueryRange methods
+type Querier interface {
+	BuildWithParams(params QuerierParams) Querier
+	Query(ctx context.Context, query string, ts time.Time) (Result, error)
+	QueryRange(ctx context.Context, query string, start, end time.Time) (Result, error)
+}
+
+// Result is returned by Query and QueryRange. It carries the parsed metrics
+// plus anything the datasource attached to the response that the rule
+// executor may want to surface: non-fatal warnings and, when requested,
+// query stats.
+type Result struct {
+	Data     []Metric
+	Warnings []string
+	Stats    *QueryStats
+}
+
+// QuerierBuilder builds Querier with given params.
+type QuerierBuilder interface {
+	BuildWithParams(params QuerierParams) Querier
+}
+
+// QuerierParams params for Querier.
+type QuerierParams struct {
+	DataSourceType     *Type
+	EvaluationInterval time.Duration
+	QueryParams        url.Values
+
+	// MaxAttempts, RetryMinInterval and RetryMaxInterval, when non-zero,
+	// override the datasource's default retry policy for the built Querier.
+	MaxAttempts      int
+	RetryMinInterval time.Duration
+	RetryMaxInterval time.Duration
+
+	// Headers are merged into every request issued by the built Querier,
+	// e.g. Group-level tenant headers configured in vmalert config.
+	Headers http.Header
+}
+
+// Label represents metric label
+type Label struct {
+	Name  string
+	Value string
+}
+
+// Metric is the basic entity which should be return by datasource
+type Metric struct {
+	Labels     []Label
+	Timestamps []int64
+	Values     []float64
+}
+
+// SetLabel adds or updates metric's label
+func (m *Metric) SetLabel(key, value string) {
+	for i, l := range m.Labels {
+		if l.Name == key {
+			m.Labels[i].Value = value
+			return
+		}
+	}
+	m.Labels = append(m.Labels, Label{Name: key, Value: value})
+}
+
+// Label returns the value of the label with the given name.
+func (m *Metric) Label(key string) string {
+	for _, l := range m.Labels {
+		if l.Name == key {
+			return l.Value
+		}
+	}
+	return ""
+}
+
+const (
+	prometheusDatasource = "prometheus"
+	graphiteDatasource   = "graphite"
+)
+
+// Type represents data source type
+type Type struct {
+	name string
+}
+
+// NewPrometheusType returns prometheus datasource type
+func NewPrometheusType() Type {
+	return Type{name: prometheusDatasource}
+}
+
+// NewGraphiteType returns graphite datasource type
+func NewGraphiteType() Type {
+	return Type{name: graphiteDatasource}
+}
+
+// String implements Stringer interface
+func (t Type) String() string {
+	if t.name == "" {
+		return prometheusDatasource
+	}
+	return t.name
+}
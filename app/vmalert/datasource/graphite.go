@@ -0,0 +1,62 @@
+package datasource
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// graphiteReqParams returns the query params for graphite's render API.
+func (s *VMStorage) graphiteReqParams(query string, timestamp time.Time) url.Values {
+	q := url.Values{}
+	q.Set("format", "json")
+	q.Set("target", query)
+	from := "-" + (s.lookBack).String()
+	if s.lookBack == 0 {
+		from = "-5min"
+	}
+	q.Set("from", from)
+	q.Set("until", timestamp.Format("15:04_20060102"))
+	return q
+}
+
+type graphiteResponse []graphiteResponseTarget
+
+type graphiteResponseTarget struct {
+	Target     string            `json:"target"`
+	Tags       map[string]string `json:"tags"`
+	Datapoints [][2]*float64     `json:"datapoints"`
+}
+
+// parseGraphiteResponse parses the response from graphite's render API into a list of Metric.
+func parseGraphiteResponse(req *http.Request, resp *http.Response) ([]Metric, error) {
+	r := graphiteResponse{}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading response from %s: %w", req.URL.Redacted(), err)
+	}
+	if err := json.Unmarshal(body, &r); err != nil {
+		return nil, fmt.Errorf("error parsing response from %s: %w; response body: %s", req.URL.Redacted(), err, body)
+	}
+	metrics := make([]Metric, 0, len(r))
+	for _, target := range r {
+		var m Metric
+		m.SetLabel("name", target.Target)
+		for k, v := range target.Tags {
+			m.SetLabel(k, v)
+		}
+		for _, dp := range target.Datapoints {
+			if dp[0] == nil || dp[1] == nil {
+				// missing value at this timestamp
+				continue
+			}
+			m.Timestamps = append(m.Timestamps, int64(*dp[1])*1e3)
+			m.Values = append(m.Values, *dp[0])
+		}
+		metrics = append(metrics, m)
+	}
+	return metrics, nil
+}
@@ -0,0 +1,147 @@
+package datasource
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// prometheusInstantReqParams returns the query params for an instant query.
+func (s *VMStorage) prometheusInstantReqParams(query string, timestamp time.Time) url.Values {
+	q := url.Values{}
+	q.Set("query", query)
+	if s.lookBack > 0 {
+		timestamp = timestamp.Add(-s.lookBack)
+	}
+	q.Set("time", timestamp.Format(time.RFC3339))
+	return q
+}
+
+// prometheusRangeReqParams returns the query params for a range query.
+func (s *VMStorage) prometheusRangeReqParams(query string, start, end time.Time) url.Values {
+	q := url.Values{}
+	q.Set("query", query)
+	q.Set("start", start.Format(time.RFC3339))
+	q.Set("end", end.Format(time.RFC3339))
+	if s.queryStep > 0 {
+		q.Set("step", fmt.Sprintf("%ds", int(s.queryStep.Seconds())))
+	}
+	return q
+}
+
+// promResponse represents the top-level envelope returned by Prometheus-compatible
+// HTTP API for both instant and range queries.
+// See https://prometheus.io/docs/prometheus/latest/querying/api/
+type promResponse struct {
+	Status    string           `json:"status"`
+	ErrorType string           `json:"errorType"`
+	Error     string           `json:"error"`
+	Warnings  []string         `json:"warnings,omitempty"`
+	Stats     *QueryStats      `json:"stats,omitempty"`
+	Data      promResponseData `json:"data"`
+}
+
+type promResponseData struct {
+	ResultType string          `json:"resultType"`
+	Result     json.RawMessage `json:"result"`
+}
+
+type promInstant struct {
+	Metric map[string]string `json:"metric"`
+	Value  [2]interface{}    `json:"value"`
+}
+
+type promRange struct {
+	Metric map[string]string `json:"metric"`
+	Values [][2]interface{}  `json:"values"`
+}
+
+// parsePrometheusResponse parses the response body returned from Prometheus-compatible API
+// and returns it as a list of Metric, together with any warnings/stats attached to the response.
+func parsePrometheusResponse(req *http.Request, resp *http.Response) ([]Metric, *promResponse, error) {
+	r := &promResponse{}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error reading response from %s: %w", req.URL.Redacted(), err)
+	}
+	if err := json.Unmarshal(body, r); err != nil {
+		return nil, nil, fmt.Errorf("error parsing response from %s: %w; response body: %s", req.URL.Redacted(), err, body)
+	}
+	if r.Status == "error" {
+		return nil, nil, fmt.Errorf("error from %s: %s: %s", req.URL.Redacted(), r.ErrorType, r.Error)
+	}
+	switch r.Data.ResultType {
+	case "vector":
+		var instants []promInstant
+		if err := json.Unmarshal(r.Data.Result, &instants); err != nil {
+			return nil, nil, fmt.Errorf("error parsing `vector` result from %s: %w", req.URL.Redacted(), err)
+		}
+		metrics := make([]Metric, 0, len(instants))
+		for _, ins := range instants {
+			m, err := toMetric(ins.Metric, ins.Value)
+			if err != nil {
+				return nil, nil, err
+			}
+			metrics = append(metrics, m)
+		}
+		return metrics, r, nil
+	case "matrix":
+		var ranges []promRange
+		if err := json.Unmarshal(r.Data.Result, &ranges); err != nil {
+			return nil, nil, fmt.Errorf("error parsing `matrix` result from %s: %w", req.URL.Redacted(), err)
+		}
+		metrics := make([]Metric, 0, len(ranges))
+		for _, rng := range ranges {
+			var m Metric
+			for k, v := range rng.Metric {
+				m.SetLabel(k, v)
+			}
+			for _, pair := range rng.Values {
+				ts, value, err := parseSamplePair(pair)
+				if err != nil {
+					return nil, nil, err
+				}
+				m.Timestamps = append(m.Timestamps, ts)
+				m.Values = append(m.Values, value)
+			}
+			metrics = append(metrics, m)
+		}
+		return metrics, r, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown result type %q from %s", r.Data.ResultType, req.URL.Redacted())
+	}
+}
+
+func toMetric(labels map[string]string, pair [2]interface{}) (Metric, error) {
+	var m Metric
+	for k, v := range labels {
+		m.SetLabel(k, v)
+	}
+	ts, value, err := parseSamplePair(pair)
+	if err != nil {
+		return m, err
+	}
+	m.Timestamps = []int64{ts}
+	m.Values = []float64{value}
+	return m, nil
+}
+
+func parseSamplePair(pair [2]interface{}) (int64, float64, error) {
+	tsF, ok := pair[0].(float64)
+	if !ok {
+		return 0, 0, fmt.Errorf("unexpected timestamp type %T in sample pair", pair[0])
+	}
+	valStr, ok := pair[1].(string)
+	if !ok {
+		return 0, 0, fmt.Errorf("unexpected value type %T in sample pair", pair[1])
+	}
+	value, err := strconv.ParseFloat(valStr, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("error parsing sample value %q: %w", valStr, err)
+	}
+	return int64(tsF * 1e3), value, nil
+}
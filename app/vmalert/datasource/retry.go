@@ -0,0 +1,176 @@
+package datasource
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/VictoriaMetrics/metrics"
+)
+
+const (
+	defaultMaxAttempts  = 3
+	defaultMinRetryWait = 500 * time.Millisecond
+	defaultMaxRetryWait = 5 * time.Second
+)
+
+var requestDuration = metrics.NewHistogram(`vmalert_datasource_request_duration_seconds`)
+
+// isRetryableStatusCode reports whether resp's status code is worth retrying.
+func isRetryableStatusCode(code int) bool {
+	switch code {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
+	}
+}
+
+// isRetryableError reports whether err, returned by the underlying http.Client,
+// is worth retrying. Context cancellation and deadline expiration are never
+// retried, since re-issuing the request with an already-done context can't succeed.
+func isRetryableError(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	return true
+}
+
+// retryAfter parses the Retry-After header, returning 0 if absent or invalid.
+func retryAfter(resp *http.Response) time.Duration {
+	if resp == nil {
+		return 0
+	}
+	v := resp.Header.Get("Retry-After")
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+// backoffDuration returns the delay before the given attempt (0-based),
+// exponentially increasing from min up to max, with +/-20% jitter.
+func backoffDuration(attempt int, minWait, maxWait time.Duration) time.Duration {
+	d := minWait << uint(attempt)
+	if d <= 0 || d > maxWait { // overflow or past ceiling
+		d = maxWait
+	}
+	jitter := time.Duration(rand.Int63n(int64(d)/5 + 1)) // up to 20%
+	if rand.Intn(2) == 0 {
+		return d - jitter
+	}
+	return d + jitter
+}
+
+// httpStatusError wraps a non-200 response so callers can branch on the
+// status code without re-parsing the error message.
+type httpStatusError struct {
+	code int
+	err  error
+}
+
+func (e *httpStatusError) Error() string { return e.err.Error() }
+func (e *httpStatusError) Unwrap() error { return e.err }
+
+// do builds and sends a request via newReq, retrying transient failures with
+// exponential backoff. newReq is called again on every attempt so that
+// request bodies (e.g. a form-encoded POST body) are re-created fresh instead
+// of being replayed from an already-drained reader.
+func (s *VMStorage) do(ctx context.Context, newReq func() (*http.Request, error)) (*http.Request, *http.Response, error) {
+	maxAttempts := s.maxAttempts
+	if maxAttempts < 1 {
+		maxAttempts = defaultMaxAttempts
+	}
+	minWait := s.retryMinInterval
+	if minWait <= 0 {
+		minWait = defaultMinRetryWait
+	}
+	maxWait := s.retryMaxInterval
+	if maxWait <= 0 {
+		maxWait = defaultMaxRetryWait
+	}
+
+	var lastErr error
+	var lastReq *http.Request
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		req, err := newReq()
+		if err != nil {
+			return nil, nil, err
+		}
+		lastReq = req
+
+		start := time.Now()
+		resp, err := s.c.Do(req.WithContext(ctx))
+		requestDuration.UpdateDuration(start)
+
+		if err != nil {
+			lastErr = fmt.Errorf("error getting response from %s: %w", req.URL.Redacted(), err)
+			if attempt == maxAttempts-1 || !isRetryableError(ctx, err) {
+				return lastReq, nil, lastErr
+			}
+			requestRetriesTotal(req.URL.Host, 0).Inc()
+			sleepBackoff(ctx, backoffDuration(attempt, minWait, maxWait))
+			continue
+		}
+
+		if resp.StatusCode == http.StatusOK {
+			return req, resp, nil
+		}
+
+		body, _ := ioutilReadAllAndClose(resp)
+		lastErr = &httpStatusError{
+			code: resp.StatusCode,
+			err:  fmt.Errorf("unexpected response code %d for %s. Response body %s", resp.StatusCode, req.URL.Redacted(), body),
+		}
+		if attempt == maxAttempts-1 || !isRetryableStatusCode(resp.StatusCode) {
+			return lastReq, nil, lastErr
+		}
+		requestRetriesTotal(req.URL.Host, resp.StatusCode).Inc()
+		wait := retryAfter(resp)
+		if wait == 0 {
+			wait = backoffDuration(attempt, minWait, maxWait)
+		}
+		sleepBackoff(ctx, wait)
+	}
+	return lastReq, nil, lastErr
+}
+
+// ioutilReadAllAndClose drains and closes resp.Body, returning whatever could be read.
+func ioutilReadAllAndClose(resp *http.Response) ([]byte, error) {
+	body, err := ioutil.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	return body, err
+}
+
+// requestRetriesTotal is labeled by the datasource host, not the full request
+// URL: the query string carries the PromQL query text, so using it as a label
+// would mint an unbounded, query-text-leaking series per distinct rule expression.
+func requestRetriesTotal(host string, code int) *metrics.Counter {
+	return metrics.GetOrCreateCounter(fmt.Sprintf(`vmalert_datasource_request_retries_total{url=%q, code="%d"}`, host, code))
+}
+
+// sleepBackoff sleeps for d or until ctx is done, whichever comes first.
+func sleepBackoff(ctx context.Context, d time.Duration) {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+	case <-t.C:
+	}
+}
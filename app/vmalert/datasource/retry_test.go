@@ -0,0 +1,82 @@
+package datasource
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestBackoffDurationBounds(t *testing.T) {
+	minWait := 100 * time.Millisecond
+	maxWait := 2 * time.Second
+	for attempt := 0; attempt < 10; attempt++ {
+		for i := 0; i < 20; i++ {
+			d := backoffDuration(attempt, minWait, maxWait)
+			if d < 0 {
+				t.Fatalf("attempt %d: backoffDuration returned negative duration %s", attempt, d)
+			}
+			if d > maxWait+maxWait/5 {
+				t.Fatalf("attempt %d: backoffDuration %s exceeds maxWait %s by more than jitter bound", attempt, d, maxWait)
+			}
+		}
+	}
+}
+
+func TestIsRetryableStatusCode(t *testing.T) {
+	cases := map[int]bool{
+		http.StatusTooManyRequests:     true,
+		http.StatusBadGateway:          true,
+		http.StatusServiceUnavailable:  true,
+		http.StatusGatewayTimeout:      true,
+		http.StatusOK:                  false,
+		http.StatusBadRequest:          false,
+		http.StatusInternalServerError: false,
+		http.StatusNotFound:            false,
+	}
+	for code, want := range cases {
+		if got := isRetryableStatusCode(code); got != want {
+			t.Errorf("isRetryableStatusCode(%d) = %v, want %v", code, got, want)
+		}
+	}
+}
+
+func TestIsRetryableError(t *testing.T) {
+	ctx := context.Background()
+	if !isRetryableError(ctx, errors.New("connection reset by peer")) {
+		t.Errorf("a plain transport error on a live context should be retryable")
+	}
+	if isRetryableError(ctx, context.DeadlineExceeded) {
+		t.Errorf("a context.DeadlineExceeded error should never be retried")
+	}
+
+	cancelCtx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if isRetryableError(cancelCtx, context.Canceled) {
+		t.Errorf("an error on an already-done context must not be retryable")
+	}
+}
+
+func TestRetryAfterSeconds(t *testing.T) {
+	resp := &http.Response{Header: http.Header{"Retry-After": []string{"5"}}}
+	if d := retryAfter(resp); d != 5*time.Second {
+		t.Fatalf("retryAfter() = %s, want 5s", d)
+	}
+}
+
+func TestRetryAfterMissing(t *testing.T) {
+	resp := &http.Response{Header: http.Header{}}
+	if d := retryAfter(resp); d != 0 {
+		t.Fatalf("retryAfter() = %s, want 0", d)
+	}
+}
+
+func TestRequestRetriesTotalLabelsByHostOnly(t *testing.T) {
+	// a counter keyed on host must be the same series regardless of query text
+	c1 := requestRetriesTotal("vmselect:8481", 503)
+	c2 := requestRetriesTotal("vmselect:8481", 503)
+	if c1 != c2 {
+		t.Fatalf("expected the same counter instance for the same host+code")
+	}
+}
@@ -0,0 +1,62 @@
+package datasource
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// QueryStats mirrors the `stats` object returned by the Prometheus HTTP API
+// when a query is executed with the `stats=all` query param.
+// See https://prometheus.io/docs/prometheus/latest/querying/api/#instant-queries
+type QueryStats struct {
+	Timings QueryTimings `json:"timings"`
+	Samples QuerySamples `json:"samples"`
+}
+
+// QueryTimings contains the per-stage timings of a single query evaluation, in seconds.
+type QueryTimings struct {
+	EvalTotalTime        float64 `json:"evalTotalTime"`
+	ResultSortTime       float64 `json:"resultSortTime"`
+	QueryPreparationTime float64 `json:"queryPreparationTime"`
+	InnerEvalTime        float64 `json:"innerEvalTime"`
+	ExecQueueTime        float64 `json:"execQueueTime"`
+	ExecTotalTime        float64 `json:"execTotalTime"`
+}
+
+// QuerySamples contains the amount of samples touched while evaluating a query.
+type QuerySamples struct {
+	TotalQueryableSamples int64 `json:"totalQueryableSamples"`
+	PeakSamples           int64 `json:"peakSamples"`
+	// TotalQueryableSamplesPerStep is only populated for range queries.
+	TotalQueryableSamplesPerStep []StepSamples `json:"totalQueryableSamplesPerStep,omitempty"`
+}
+
+// StepSamples is a single (timestamp, samples count) pair of TotalQueryableSamplesPerStep.
+type StepSamples struct {
+	TimestampMs int64
+	Value       int64
+}
+
+// UnmarshalJSON unmarshals a `[timestamp, "value"]` pair as returned by Prometheus.
+func (s *StepSamples) UnmarshalJSON(b []byte) error {
+	var pair [2]interface{}
+	if err := json.Unmarshal(b, &pair); err != nil {
+		return err
+	}
+	ts, ok := pair[0].(float64)
+	if !ok {
+		return fmt.Errorf("unexpected timestamp type %T in totalQueryableSamplesPerStep", pair[0])
+	}
+	v, ok := pair[1].(string)
+	if !ok {
+		return fmt.Errorf("unexpected value type %T in totalQueryableSamplesPerStep", pair[1])
+	}
+	value, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return fmt.Errorf("error parsing totalQueryableSamplesPerStep value %q: %w", v, err)
+	}
+	s.TimestampMs = int64(ts * 1e3)
+	s.Value = value
+	return nil
+}
@@ -0,0 +1,44 @@
+package datasource
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+	"github.com/VictoriaMetrics/metrics"
+)
+
+// ObserveQueryStats records stats, collected via QueryStats, against a
+// specific rule so operators can attribute vmalert load to individual rules.
+// It is a no-op when stats is nil, e.g. when query stats weren't requested
+// or the datasource didn't return a `stats` object.
+//
+// When slowQueryThreshold is positive and the query's total evaluation time
+// exceeds it, a log line is emitted so expensive rules can be spotted without
+// enabling query logging on vmstorage.
+func ObserveQueryStats(ruleID string, stats *QueryStats, slowQueryThreshold time.Duration) {
+	if stats == nil {
+		return
+	}
+	ruleQueryEvalSeconds(ruleID).Update(stats.Timings.EvalTotalTime)
+	ruleQuerySamplesTotal(ruleID).Add(int(stats.Samples.TotalQueryableSamples))
+
+	if slowQueryThreshold <= 0 {
+		return
+	}
+	evalTime := time.Duration(stats.Timings.EvalTotalTime * float64(time.Second))
+	if evalTime > slowQueryThreshold {
+		logger.Warnf("rule %q: query took %s which exceeds the configured slow query threshold of %s; "+
+			"queryPreparationTime=%.3fs innerEvalTime=%.3fs execQueueTime=%.3fs totalQueryableSamples=%d peakSamples=%d",
+			ruleID, evalTime, slowQueryThreshold, stats.Timings.QueryPreparationTime, stats.Timings.InnerEvalTime,
+			stats.Timings.ExecQueueTime, stats.Samples.TotalQueryableSamples, stats.Samples.PeakSamples)
+	}
+}
+
+func ruleQueryEvalSeconds(ruleID string) *metrics.Histogram {
+	return metrics.GetOrCreateHistogram(fmt.Sprintf(`vmalert_rule_query_eval_seconds{rule=%q}`, ruleID))
+}
+
+func ruleQuerySamplesTotal(ruleID string) *metrics.Counter {
+	return metrics.GetOrCreateCounter(fmt.Sprintf(`vmalert_rule_query_samples_total{rule=%q}`, ruleID))
+}
@@ -0,0 +1,49 @@
+package datasource
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestStepSamplesUnmarshalJSON(t *testing.T) {
+	var s StepSamples
+	if err := json.Unmarshal([]byte(`[1435781451.781,"1234"]`), &s); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if s.TimestampMs != 1435781451781 {
+		t.Fatalf("unexpected TimestampMs: got %d, want %d", s.TimestampMs, 1435781451781)
+	}
+	if s.Value != 1234 {
+		t.Fatalf("unexpected Value: got %d, want %d", s.Value, 1234)
+	}
+}
+
+func TestStepSamplesUnmarshalJSONError(t *testing.T) {
+	var s StepSamples
+	if err := json.Unmarshal([]byte(`[1435781451.781,1234]`), &s); err == nil {
+		t.Fatalf("expected error for non-string sample value, got nil")
+	}
+}
+
+func TestObserveQueryStatsNilIsNoop(t *testing.T) {
+	// must not panic when stats weren't requested/returned
+	ObserveQueryStats("TestRule", nil, time.Second)
+}
+
+func TestObserveQueryStatsUpdatesMetrics(t *testing.T) {
+	stats := &QueryStats{
+		Timings: QueryTimings{EvalTotalTime: 0.5},
+		Samples: QuerySamples{TotalQueryableSamples: 42},
+	}
+	before := ruleQuerySamplesTotal("TestRule2").Get()
+	ObserveQueryStats("TestRule2", stats, 0)
+
+	h := ruleQueryEvalSeconds("TestRule2")
+	if h == nil {
+		t.Fatalf("expected histogram to be registered")
+	}
+	if got := ruleQuerySamplesTotal("TestRule2").Get() - before; got != 42 {
+		t.Fatalf("unexpected samples counter increment: got %d, want %d", got, 42)
+	}
+}
@@ -2,8 +2,8 @@ package datasource
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"io/ioutil"
 	"net/http"
 	"net/url"
 	"strings"
@@ -12,11 +12,20 @@ import (
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/promauth"
 )
 
+// authHeaderSetter is the subset of *promauth.Config's surface that
+// newRequest depends on. Declaring it locally lets authCfg be exercised with
+// a lightweight test double that proves SetHeaders runs fresh before every
+// HTTP attempt, without depending on promauth.Config's own credential
+// sourcing (file, exec command, etc).
+type authHeaderSetter interface {
+	SetHeaders(req *http.Request, setAuthHeader bool)
+}
+
 // VMStorage represents vmstorage entity with ability to read and write metrics
 // WARN: when adding a new field, remember to update Clone() method.
 type VMStorage struct {
 	c                *http.Client
-	authCfg          *promauth.Config
+	authCfg          authHeaderSetter
 	datasourceURL    string
 	appendTypePrefix bool
 	lookBack         time.Duration
@@ -25,6 +34,27 @@ type VMStorage struct {
 	dataSourceType     Type
 	evaluationInterval time.Duration
 	extraParams        url.Values
+
+	// queryStats enables sending `stats=all` along with Prometheus queries
+	// so the response's `stats` object (timings and samples touched) can be
+	// returned to the caller for per-rule observability.
+	queryStats bool
+
+	// maxAttempts, retryMinInterval and retryMaxInterval configure the
+	// retry behaviour of do(). Zero values fall back to the package defaults.
+	maxAttempts      int
+	retryMinInterval time.Duration
+	retryMaxInterval time.Duration
+
+	// extraHeaders are merged into every outgoing request, after authCfg's
+	// own headers, e.g. tenant headers such as AccountID/ProjectID for
+	// VictoriaMetrics cluster multi-tenancy. Set via Group-level config
+	// through ApplyParams.
+	extraHeaders http.Header
+
+	// queryMethod is one of "get", "post" or "auto" and controls how query
+	// params are sent to the datasource, see newRequest.
+	queryMethod string
 }
 
 // Clone makes clone of VMStorage, shares http client.
@@ -39,9 +69,15 @@ func (s *VMStorage) Clone() *VMStorage {
 
 		dataSourceType:     s.dataSourceType,
 		evaluationInterval: s.evaluationInterval,
+		queryStats:         s.queryStats,
+		maxAttempts:        s.maxAttempts,
+		retryMinInterval:   s.retryMinInterval,
+		retryMaxInterval:   s.retryMaxInterval,
+		queryMethod:        s.queryMethod,
 
-		// init map so it can be populated below
-		extraParams: url.Values{},
+		// init maps so they can be populated below without mutating s
+		extraParams:  url.Values{},
+		extraHeaders: s.extraHeaders.Clone(),
 	}
 	for k, v := range s.extraParams {
 		ns.extraParams[k] = v
@@ -55,6 +91,26 @@ func (s *VMStorage) ApplyParams(params QuerierParams) *VMStorage {
 	if params.DataSourceType != nil {
 		s.dataSourceType = *params.DataSourceType
 	}
+	if params.MaxAttempts != 0 {
+		s.maxAttempts = params.MaxAttempts
+	}
+	if params.RetryMinInterval != 0 {
+		s.retryMinInterval = params.RetryMinInterval
+	}
+	if params.RetryMaxInterval != 0 {
+		s.retryMaxInterval = params.RetryMaxInterval
+	}
+	if params.Headers != nil {
+		if s.extraHeaders == nil {
+			s.extraHeaders = make(http.Header)
+		}
+		for k, vl := range params.Headers {
+			s.extraHeaders.Del(k) // Group headers replace any previously configured values for k
+			for _, v := range vl {
+				s.extraHeaders.Add(k, v)
+			}
+		}
+	}
 	s.evaluationInterval = params.EvaluationInterval
 	if params.QueryParams != nil {
 		if s.extraParams == nil {
@@ -76,98 +132,214 @@ func (s *VMStorage) BuildWithParams(params QuerierParams) Querier {
 
 // NewVMStorage is a constructor for VMStorage
 func NewVMStorage(baseURL string, authCfg *promauth.Config, lookBack time.Duration, queryStep time.Duration, appendTypePrefix bool, c *http.Client) *VMStorage {
-	return &VMStorage{
+	s := &VMStorage{
 		c:                c,
-		authCfg:          authCfg,
 		datasourceURL:    strings.TrimSuffix(baseURL, "/"),
 		appendTypePrefix: appendTypePrefix,
 		lookBack:         lookBack,
 		queryStep:        queryStep,
 		dataSourceType:   NewPrometheusType(),
 		extraParams:      url.Values{},
+		maxAttempts:      defaultMaxAttempts,
+		retryMinInterval: defaultMinRetryWait,
+		retryMaxInterval: defaultMaxRetryWait,
+		queryMethod:      queryMethodPost,
+	}
+	if authCfg != nil {
+		// Store authCfg only when non-nil: assigning a nil *promauth.Config
+		// into the authHeaderSetter interface field would produce a non-nil
+		// interface wrapping a nil pointer, so the "authCfg != nil" check in
+		// newRequest would wrongly see it as configured.
+		s.authCfg = authCfg
 	}
+	return s
 }
 
-// Query executes the given query and returns parsed response
-func (s *VMStorage) Query(ctx context.Context, query string, ts time.Time) ([]Metric, error) {
-	req, err := s.newRequestPOST()
-	if err != nil {
-		return nil, err
-	}
+// WithQueryMethod sets how query params are sent to the datasource: "get",
+// "post" or "auto" (GET for small queries, falling back to POST on
+// 414/405). An empty or unrecognized value behaves like "post".
+func (s *VMStorage) WithQueryMethod(method string) *VMStorage {
+	s.queryMethod = method
+	return s
+}
 
+// WithRetryPolicy overrides the default retry policy used by do(). maxAttempts
+// is the total number of tries (1 disables retries); minInterval/maxInterval
+// bound the exponential backoff applied between attempts.
+func (s *VMStorage) WithRetryPolicy(maxAttempts int, minInterval, maxInterval time.Duration) *VMStorage {
+	s.maxAttempts = maxAttempts
+	s.retryMinInterval = minInterval
+	s.retryMaxInterval = maxInterval
+	return s
+}
+
+// WithQueryStats enables or disables sending `stats=all` with Prometheus queries.
+// When enabled, Query and QueryRange return the stats attached to the response.
+func (s *VMStorage) WithQueryStats(enable bool) *VMStorage {
+	s.queryStats = enable
+	return s
+}
+
+// Query executes the given query and returns parsed response.
+// Result.Stats is non-nil only when query stats were requested and the
+// datasource returned a `stats` object, e.g. for the prometheus type with
+// queryStats enabled. Result.Warnings carries any non-fatal warnings the
+// datasource attached to the response; these never cause Query to fail.
+func (s *VMStorage) Query(ctx context.Context, query string, ts time.Time) (Result, error) {
+	var params url.Values
 	switch s.dataSourceType.String() {
 	case "prometheus":
-		s.setPrometheusInstantReqParams(req, query, ts)
+		params = s.prometheusInstantReqParams(query, ts)
+		if s.queryStats {
+			params.Set("stats", "all")
+		}
 	case "graphite":
-		s.setGraphiteReqParams(req, query, ts)
+		params = s.graphiteReqParams(query, ts)
 	default:
-		return nil, fmt.Errorf("engine not found: %q", s.dataSourceType.name)
+		return Result{}, fmt.Errorf("engine not found: %q", s.dataSourceType.name)
 	}
 
-	resp, err := s.do(ctx, req)
+	req, resp, err := s.doQuery(ctx, params)
 	if err != nil {
-		return nil, err
+		return Result{}, err
 	}
 	defer func() {
 		_ = resp.Body.Close()
 	}()
 
-	parseFn := parsePrometheusResponse
 	if s.dataSourceType.name != "prometheus" {
-		parseFn = parseGraphiteResponse
+		metrics, err := parseGraphiteResponse(req, resp)
+		if err != nil {
+			return Result{}, err
+		}
+		return Result{Data: metrics}, nil
+	}
+	metrics, pr, err := parsePrometheusResponse(req, resp)
+	if err != nil {
+		return Result{}, err
 	}
-	return parseFn(req, resp)
+	res := Result{Data: metrics, Warnings: pr.Warnings}
+	if s.queryStats {
+		res.Stats = pr.Stats
+	}
+	return res, nil
 }
 
 // QueryRange executes the given query on the given time range.
 // For Prometheus type see https://prometheus.io/docs/prometheus/latest/querying/api/#range-queries
 // Graphite type isn't supported.
-func (s *VMStorage) QueryRange(ctx context.Context, query string, start, end time.Time) ([]Metric, error) {
+func (s *VMStorage) QueryRange(ctx context.Context, query string, start, end time.Time) (Result, error) {
 	if s.dataSourceType.name != "prometheus" {
-		return nil, fmt.Errorf("%q is not supported for QueryRange", s.dataSourceType.name)
-	}
-	req, err := s.newRequestPOST()
-	if err != nil {
-		return nil, err
+		return Result{}, fmt.Errorf("%q is not supported for QueryRange", s.dataSourceType.name)
 	}
 	if start.IsZero() {
-		return nil, fmt.Errorf("start param is missing")
+		return Result{}, fmt.Errorf("start param is missing")
 	}
 	if end.IsZero() {
-		return nil, fmt.Errorf("end param is missing")
+		return Result{}, fmt.Errorf("end param is missing")
+	}
+	params := s.prometheusRangeReqParams(query, start, end)
+	if s.queryStats {
+		params.Set("stats", "all")
 	}
-	s.setPrometheusRangeReqParams(req, query, start, end)
-	resp, err := s.do(ctx, req)
+	req, resp, err := s.doQuery(ctx, params)
 	if err != nil {
-		return nil, err
+		return Result{}, err
 	}
 	defer func() {
 		_ = resp.Body.Close()
 	}()
-	return parsePrometheusResponse(req, resp)
+	metrics, pr, err := parsePrometheusResponse(req, resp)
+	if err != nil {
+		return Result{}, err
+	}
+	res := Result{Data: metrics, Warnings: pr.Warnings}
+	if s.queryStats {
+		res.Stats = pr.Stats
+	}
+	return res, nil
 }
 
-func (s *VMStorage) do(ctx context.Context, req *http.Request) (*http.Response, error) {
-	resp, err := s.c.Do(req.WithContext(ctx))
-	if err != nil {
-		return nil, fmt.Errorf("error getting response from %s: %w", req.URL.Redacted(), err)
+const (
+	queryMethodGet  = "get"
+	queryMethodPost = "post"
+	queryMethodAuto = "auto"
+
+	// autoGETSizeThreshold is the encoded query size under which "auto" mode
+	// prefers GET, so short queries can be cached by HTTP caches/CDNs sitting
+	// in front of vmselect.
+	autoGETSizeThreshold = 2048
+)
+
+// doQuery picks an HTTP method for params according to s.queryMethod and
+// sends the request via do(). In "auto" mode, a GET attempt that is rejected
+// with 414 (URI Too Long) or 405 (Method Not Allowed) is retried once as POST;
+// an explicitly configured "get" is never silently switched to POST.
+func (s *VMStorage) doQuery(ctx context.Context, params url.Values) (*http.Request, *http.Response, error) {
+	for k, vl := range s.extraParams {
+		params[k] = vl // custom query params are prior to default ones
 	}
-	if resp.StatusCode != http.StatusOK {
-		body, _ := ioutil.ReadAll(resp.Body)
-		_ = resp.Body.Close()
-		return nil, fmt.Errorf("unexpected response code %d for %s. Response body %s", resp.StatusCode, req.URL.Redacted(), body)
+	configured := s.queryMethod
+	method := configured
+	switch configured {
+	case queryMethodGet, queryMethodPost:
+	case queryMethodAuto:
+		if len(params.Encode()) < autoGETSizeThreshold {
+			method = queryMethodGet
+		} else {
+			method = queryMethodPost
+		}
+	default:
+		method = queryMethodPost
 	}
-	return resp, nil
+
+	req, resp, err := s.do(ctx, func() (*http.Request, error) {
+		return s.newRequest(method, params)
+	})
+	if err != nil && configured == queryMethodAuto && method == queryMethodGet {
+		var se *httpStatusError
+		if errors.As(err, &se) && (se.code == http.StatusRequestURITooLong || se.code == http.StatusMethodNotAllowed) {
+			return s.do(ctx, func() (*http.Request, error) {
+				return s.newRequest(queryMethodPost, params)
+			})
+		}
+	}
+	return req, resp, err
 }
 
-func (s *VMStorage) newRequestPOST() (*http.Request, error) {
-	req, err := http.NewRequest("POST", s.datasourceURL, nil)
+// newRequest builds an HTTP request for params using the given method.
+// For GET, params are encoded into the URL query string. For POST, params
+// are form-encoded into the request body with a matching Content-Type,
+// the same encoding used by Prometheus' own HTTP API client.
+func (s *VMStorage) newRequest(method string, params url.Values) (*http.Request, error) {
+	var req *http.Request
+	var err error
+	if method == queryMethodGet {
+		req, err = http.NewRequest(http.MethodGet, s.datasourceURL+"?"+params.Encode(), nil)
+	} else {
+		req, err = http.NewRequest(http.MethodPost, s.datasourceURL, strings.NewReader(params.Encode()))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+	}
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Set("Content-Type", "application/json")
 	if s.authCfg != nil {
+		// newRequest is called fresh on every retry attempt (do() in retry.go
+		// invokes its newReq callback once per attempt, not once per Query),
+		// so SetHeaders runs again before each individual HTTP request. This
+		// is the hook an authCfg that refreshes short-lived bearer tokens
+		// (e.g. re-reading a file or running an exec command, as
+		// promauth.Config does) needs: it's never handed a cached request
+		// from an earlier attempt.
 		s.authCfg.SetHeaders(req, true)
 	}
+	for k, vl := range s.extraHeaders {
+		req.Header.Del(k) // extraHeaders replace any value authCfg may have set for k
+		for _, v := range vl {
+			req.Header.Add(k, v)
+		}
+	}
 	return req, nil
 }
@@ -0,0 +1,176 @@
+package datasource
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestApplyParamsPreservesMultiValueHeaders(t *testing.T) {
+	s := NewVMStorage("http://localhost", nil, 0, 0, false, http.DefaultClient)
+	s.ApplyParams(QuerierParams{Headers: http.Header{"X-Tag": []string{"a", "b"}}})
+
+	got := s.extraHeaders.Values("X-Tag")
+	if len(got) != 2 || got[0] != "a" || got[1] != "b" {
+		t.Fatalf("ApplyParams collapsed multi-value header: got %v, want [a b]", got)
+	}
+}
+
+func TestApplyParamsHeadersOverrideExisting(t *testing.T) {
+	s := NewVMStorage("http://localhost", nil, 0, 0, false, http.DefaultClient)
+	s.ApplyParams(QuerierParams{Headers: http.Header{"X-Tag": []string{"old"}}})
+	s.ApplyParams(QuerierParams{Headers: http.Header{"X-Tag": []string{"new1", "new2"}}})
+
+	got := s.extraHeaders.Values("X-Tag")
+	if len(got) != 2 || got[0] != "new1" || got[1] != "new2" {
+		t.Fatalf("second ApplyParams call didn't replace previous values: got %v", got)
+	}
+}
+
+func TestNewRequestPreservesMultiValueExtraHeaders(t *testing.T) {
+	s := NewVMStorage("http://localhost", nil, 0, 0, false, http.DefaultClient)
+	s.extraHeaders = http.Header{"X-Tag": []string{"a", "b"}}
+
+	req, err := s.newRequest(queryMethodGet, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	got := req.Header.Values("X-Tag")
+	if len(got) != 2 || got[0] != "a" || got[1] != "b" {
+		t.Fatalf("newRequest collapsed multi-value header: got %v, want [a b]", got)
+	}
+}
+
+func TestNewRequestEncodesParamsPerMethod(t *testing.T) {
+	s := NewVMStorage("http://localhost/api/v1/query", nil, 0, 0, false, http.DefaultClient)
+	params := url.Values{"query": []string{"up"}}
+
+	getReq, err := s.newRequest(queryMethodGet, params)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if getReq.Method != http.MethodGet {
+		t.Fatalf("expected GET method, got %s", getReq.Method)
+	}
+	if getReq.URL.Query().Get("query") != "up" {
+		t.Fatalf("expected query param in URL for GET, got %q", getReq.URL.RawQuery)
+	}
+	if getReq.Body != nil {
+		t.Fatalf("GET request must not have a body")
+	}
+
+	postReq, err := s.newRequest(queryMethodPost, params)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if postReq.Method != http.MethodPost {
+		t.Fatalf("expected POST method, got %s", postReq.Method)
+	}
+	if ct := postReq.Header.Get("Content-Type"); ct != "application/x-www-form-urlencoded" {
+		t.Fatalf("unexpected Content-Type for POST: %q", ct)
+	}
+	if postReq.URL.RawQuery != "" {
+		t.Fatalf("POST request must not carry params in the URL, got %q", postReq.URL.RawQuery)
+	}
+	body, _ := ioutil.ReadAll(postReq.Body)
+	if string(body) != params.Encode() {
+		t.Fatalf("unexpected POST body: got %q, want %q", body, params.Encode())
+	}
+}
+
+func TestDoQueryFallsBackToPOSTOnlyInAutoMode(t *testing.T) {
+	var gotMethods []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotMethods = append(gotMethods, r.Method)
+		if r.Method == http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`{"status":"success","data":{"resultType":"vector","result":[]}}`))
+	}))
+	defer srv.Close()
+
+	s := NewVMStorage(srv.URL, nil, 0, 0, false, srv.Client()).WithQueryMethod(queryMethodAuto)
+	if _, err := s.Query(context.Background(), "up", time.Now()); err != nil {
+		t.Fatalf("unexpected error in auto mode: %s", err)
+	}
+	if len(gotMethods) != 2 || gotMethods[0] != http.MethodGet || gotMethods[1] != http.MethodPost {
+		t.Fatalf("expected auto mode to fall back GET->POST on 405, got %v", gotMethods)
+	}
+
+	gotMethods = nil
+	s2 := NewVMStorage(srv.URL, nil, 0, 0, false, srv.Client()).WithQueryMethod(queryMethodGet)
+	if _, err := s2.Query(context.Background(), "up", time.Now()); err == nil {
+		t.Fatalf("expected an error for explicit GET mode against a GET-rejecting server")
+	}
+	if len(gotMethods) != 1 || gotMethods[0] != http.MethodGet {
+		t.Fatalf("explicit \"get\" mode must not fall back to POST, got %v", gotMethods)
+	}
+}
+
+// countingAuthSetter is a minimal authHeaderSetter double that stamps a new
+// bearer token on every call, standing in for an authCfg that refreshes a
+// short-lived token read from a file or exec command.
+type countingAuthSetter struct {
+	calls int
+}
+
+func (c *countingAuthSetter) SetHeaders(req *http.Request, _ bool) {
+	c.calls++
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer token-%d", c.calls))
+}
+
+func TestNewRequestCallsAuthCfgFreshOnEveryCall(t *testing.T) {
+	s := NewVMStorage("http://localhost", nil, 0, 0, false, http.DefaultClient)
+	auth := &countingAuthSetter{}
+	s.authCfg = auth
+
+	req1, err := s.newRequest(queryMethodGet, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got := req1.Header.Get("Authorization"); got != "Bearer token-1" {
+		t.Fatalf("unexpected Authorization on first request: got %q", got)
+	}
+
+	req2, err := s.newRequest(queryMethodGet, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got := req2.Header.Get("Authorization"); got != "Bearer token-2" {
+		t.Fatalf("second newRequest call did not get a freshly set token: got %q", got)
+	}
+}
+
+func TestDoQueryRefreshesAuthHeaderOnRetry(t *testing.T) {
+	var attempt int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempt++
+		if attempt == 1 {
+			// force a retry so the second attempt exercises a fresh newRequest call
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer token-2" {
+			t.Errorf("retried request did not carry a freshly refreshed token: got %q", got)
+		}
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`{"status":"success","data":{"resultType":"vector","result":[]}}`))
+	}))
+	defer srv.Close()
+
+	s := NewVMStorage(srv.URL, nil, 0, 0, false, srv.Client()).WithRetryPolicy(3, time.Millisecond, time.Millisecond)
+	s.authCfg = &countingAuthSetter{}
+	if _, err := s.Query(context.Background(), "up", time.Now()); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if attempt != 2 {
+		t.Fatalf("expected exactly one retry, got %d attempts", attempt)
+	}
+}
@@ -0,0 +1,65 @@
+package datasource
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+	"github.com/VictoriaMetrics/metrics"
+)
+
+// maxTrackedWarningRules bounds lastWarnings so a long-running vmalert that
+// reloads its config many times over (rules renamed or removed, new ones
+// taking their place) doesn't accumulate one entry per distinct ruleID ever
+// seen. ForgetRule is the precise way to clean up a single rule's entry on
+// deletion; this cap is just a backstop for callers that don't call it.
+const maxTrackedWarningRules = 10000
+
+var (
+	lastWarningsMu sync.Mutex
+	lastWarnings   = make(map[string]string)
+)
+
+// ObserveWarnings increments vmalert_recording_rules_warnings_total or
+// vmalert_alerting_rules_warnings_total for ruleID, depending on isRecording,
+// and logs the warnings only when they differ from the last set logged for
+// that rule, so a warning repeated on every evaluation is logged once.
+func ObserveWarnings(ruleID string, isRecording bool, warnings []string) {
+	if len(warnings) == 0 {
+		return
+	}
+	counterName := "vmalert_alerting_rules_warnings_total"
+	if isRecording {
+		counterName = "vmalert_recording_rules_warnings_total"
+	}
+	metrics.GetOrCreateCounter(fmt.Sprintf(`%s{rule=%q}`, counterName, ruleID)).Inc()
+
+	joined := strings.Join(warnings, "; ")
+	lastWarningsMu.Lock()
+	changed := lastWarnings[ruleID] != joined
+	if changed {
+		if _, ok := lastWarnings[ruleID]; !ok && len(lastWarnings) >= maxTrackedWarningRules {
+			// Backstop: the tracked rule set grew past the cap without ever
+			// being pruned via ForgetRule. Drop it all rather than grow
+			// without bound; the only cost is a few duplicate log lines
+			// for rules whose dedup state was just reset.
+			lastWarnings = make(map[string]string)
+		}
+		lastWarnings[ruleID] = joined
+	}
+	lastWarningsMu.Unlock()
+	if changed {
+		logger.Warnf("rule %q: datasource returned warnings: %s", ruleID, joined)
+	}
+}
+
+// ForgetRule removes ruleID's warning-dedup state. Callers that manage rule
+// lifecycles should call this when a rule is deleted or renamed across a
+// config reload, so lastWarnings doesn't keep an entry for a rule that will
+// never be observed again. Safe to call for a ruleID that was never seen.
+func ForgetRule(ruleID string) {
+	lastWarningsMu.Lock()
+	delete(lastWarnings, ruleID)
+	lastWarningsMu.Unlock()
+}
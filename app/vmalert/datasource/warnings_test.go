@@ -0,0 +1,91 @@
+package datasource
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestObserveWarningsDedups(t *testing.T) {
+	ruleID := "TestObserveWarningsDedups"
+
+	// first occurrence must log and increment
+	ObserveWarnings(ruleID, true, []string{"truncated results"})
+	lastWarningsMu.Lock()
+	got := lastWarnings[ruleID]
+	lastWarningsMu.Unlock()
+	if got != "truncated results" {
+		t.Fatalf("unexpected stored warning: got %q", got)
+	}
+
+	// repeating the same warning must not change the stored value
+	ObserveWarnings(ruleID, true, []string{"truncated results"})
+	lastWarningsMu.Lock()
+	got = lastWarnings[ruleID]
+	lastWarningsMu.Unlock()
+	if got != "truncated results" {
+		t.Fatalf("unexpected stored warning after repeat: got %q", got)
+	}
+
+	// a different warning must update the stored value
+	ObserveWarnings(ruleID, true, []string{"deprecated function used"})
+	lastWarningsMu.Lock()
+	got = lastWarnings[ruleID]
+	lastWarningsMu.Unlock()
+	if got != "deprecated function used" {
+		t.Fatalf("unexpected stored warning after change: got %q", got)
+	}
+}
+
+func TestObserveWarningsEmptyIsNoop(t *testing.T) {
+	ObserveWarnings("TestObserveWarningsEmptyIsNoop", false, nil)
+	lastWarningsMu.Lock()
+	_, ok := lastWarnings["TestObserveWarningsEmptyIsNoop"]
+	lastWarningsMu.Unlock()
+	if ok {
+		t.Fatalf("expected no entry to be recorded for empty warnings")
+	}
+}
+
+func TestForgetRuleRemovesDedupState(t *testing.T) {
+	ruleID := "TestForgetRuleRemovesDedupState"
+	ObserveWarnings(ruleID, true, []string{"truncated results"})
+	lastWarningsMu.Lock()
+	_, ok := lastWarnings[ruleID]
+	lastWarningsMu.Unlock()
+	if !ok {
+		t.Fatalf("expected an entry to be recorded before ForgetRule")
+	}
+
+	ForgetRule(ruleID)
+	lastWarningsMu.Lock()
+	_, ok = lastWarnings[ruleID]
+	lastWarningsMu.Unlock()
+	if ok {
+		t.Fatalf("expected ForgetRule to remove the rule's dedup state")
+	}
+
+	// forgetting a rule that was never observed, or already forgotten, must not panic
+	ForgetRule("TestForgetRuleRemovesDedupState-never-seen")
+}
+
+func TestObserveWarningsCapsTrackedRules(t *testing.T) {
+	lastWarningsMu.Lock()
+	lastWarnings = make(map[string]string)
+	lastWarningsMu.Unlock()
+	defer func() {
+		lastWarningsMu.Lock()
+		lastWarnings = make(map[string]string)
+		lastWarningsMu.Unlock()
+	}()
+
+	for i := 0; i < maxTrackedWarningRules+10; i++ {
+		ObserveWarnings(fmt.Sprintf("rule-%d", i), false, []string{"warning"})
+	}
+
+	lastWarningsMu.Lock()
+	size := len(lastWarnings)
+	lastWarningsMu.Unlock()
+	if size > maxTrackedWarningRules {
+		t.Fatalf("lastWarnings grew past its cap: got %d entries, want <= %d", size, maxTrackedWarningRules)
+	}
+}